@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	hlsFlag    = flag.Bool("hls", false, "segment output into an HLS playlist instead of a single mp4")
+	ladderFlag = flag.String("ladder", "1080p:5000k,720p:2800k,480p:1400k", "comma separated rendition ladder, e.g. 1080p:5000k,720p:2800k")
+	dashFlag   = flag.Bool("dash", false, "also generate a DASH manifest alongside the HLS playlist")
+)
+
+// rendition is a single quality level in the HLS ladder, e.g. 720p at 2800k.
+type rendition struct {
+	name    string
+	height  int
+	bitrate string
+}
+
+// parseLadder turns a string like "1080p:5000k,720p:2800k" into a list of renditions.
+func parseLadder(s string) ([]rendition, error) {
+	var out []rendition
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid ladder entry %q", part)
+		}
+		name := fields[0]
+		heightStr := strings.TrimSuffix(name, "p")
+		height, err := strconv.Atoi(heightStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ladder resolution %q: %s", name, err)
+		}
+		out = append(out, rendition{name: name, height: height, bitrate: fields[1]})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("ladder is empty")
+	}
+	return out, nil
+}
+
+// bitrateBufsize derives a -bufsize value from a -b:v/-maxrate bitrate string,
+// following the common rule of thumb of twice the target bitrate.
+func bitrateBufsize(bitrate string) (string, error) {
+	numStr := strings.TrimSuffix(bitrate, "k")
+	n, err := strconv.Atoi(numStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid bitrate %q: %s", bitrate, err)
+	}
+	return fmt.Sprintf("%dk", n*2), nil
+}
+
+// renditionArgs builds the -map/-c:v/-c:a argument group for one ladder
+// rendition at output index i, sharing convert()'s encoder selection
+// (selectEncoder()) and audio-track filtering (-audio-langs) instead of
+// hardcoding libx264 and the first audio track. The scale filter goes
+// through enc.scaleFilter so vaapi renditions also get the format/hwupload
+// steps that encoder needs, the same as convert()'s single-file path.
+func renditionArgs(enc encoderChoice, audio StreamInfo, hasAudio bool, i int, r rendition) ([]string, error) {
+	bufsize, err := bitrateBufsize(r.bitrate)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-map", "0:v:0"}
+	if hasAudio {
+		args = append(args, "-map", fmt.Sprintf("0:%d", audio.Index))
+	}
+	args = append(args, fmt.Sprintf("-c:v:%d", i), enc.name)
+	args = append(args, enc.indexedPresetArgs(i, *preset)...)
+	args = append(args,
+		fmt.Sprintf("-b:v:%d", i), r.bitrate,
+		fmt.Sprintf("-maxrate:v:%d", i), r.bitrate,
+		fmt.Sprintf("-bufsize:v:%d", i), bufsize,
+		fmt.Sprintf("-vf:%d", i), enc.scaleFilter(r.height),
+	)
+	if hasAudio {
+		if passthroughAudioCodecs[audio.CodecName] {
+			args = append(args, fmt.Sprintf("-c:a:%d", i), "copy")
+		} else {
+			args = append(args, fmt.Sprintf("-c:a:%d", i), "aac", fmt.Sprintf("-b:a:%d", i), "128k")
+		}
+	}
+	return args, nil
+}
+
+// convertHLS transcodes path into a ladder of HLS renditions plus a master
+// playlist under outDir. It mirrors convert()'s single-file encode but spawns
+// one -map/-b:v/-maxrate/-bufsize group per rendition and asks ffmpeg to
+// segment each into its own directory. Subtitles aren't carried into HLS
+// output; -sub-langs is rejected rather than silently ignored.
+func convertHLS(path string, outDir string) (outpath string, err error) {
+	if *subLangsFlag != "" {
+		return path, fmt.Errorf("-sub-langs is not supported together with -hls")
+	}
+
+	ladder, err := parseLadder(*ladderFlag)
+	if err != nil {
+		return path, err
+	}
+
+	info := getInfo(path)
+	audio, hasAudio := firstKeptAudioStream(info)
+	enc := selectEncoder()
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	streamDir := filepath.Join(outDir, base)
+	if err := os.MkdirAll(streamDir, 0755); err != nil {
+		return path, err
+	}
+
+	cmdArgs := append([]string{}, enc.hwaccelArgs()...)
+	cmdArgs = append(cmdArgs, "-i", path)
+	var varStreamMap []string
+	for i, r := range ladder {
+		renditionDir := filepath.Join(streamDir, r.name)
+		if err := os.MkdirAll(renditionDir, 0755); err != nil {
+			return path, err
+		}
+		args, err := renditionArgs(enc, audio, hasAudio, i, r)
+		if err != nil {
+			return path, err
+		}
+		cmdArgs = append(cmdArgs, args...)
+		if hasAudio {
+			varStreamMap = append(varStreamMap, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.name))
+		} else {
+			varStreamMap = append(varStreamMap, fmt.Sprintf("v:%d,name:%s", i, r.name))
+		}
+	}
+
+	cmdArgs = append(cmdArgs,
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(streamDir, "%v", "seg_%03d.ts"),
+		"-master_pl_name", "master.m3u8",
+		"-var_stream_map", strings.Join(varStreamMap, " "),
+		filepath.Join(streamDir, "%v", "playlist.m3u8"),
+	)
+
+	log.Printf("Segmenting %s into HLS ladder (%s)...", path, *ladderFlag)
+	cmd := exec.Command("ffmpeg", cmdArgs...)
+	if out, runErr := cmd.CombinedOutput(); runErr != nil {
+		return path, fmt.Errorf("ffmpeg hls encode failed: %s: %s", runErr, out)
+	}
+
+	if *dashFlag {
+		if err := generateDASH(path, ladder, audio, hasAudio, enc, streamDir); err != nil {
+			return path, err
+		}
+	}
+
+	if *deleteOriginal {
+		log.Printf("Removing original")
+		os.Remove(path)
+	}
+
+	log.Printf("Finished segmenting %s, master playlist at %s", path, filepath.Join(streamDir, "master.m3u8"))
+	return filepath.Join(streamDir, "master.m3u8"), nil
+}
+
+// generateDASH produces a DASH manifest for the same ladder using ffmpeg's
+// dash muxer, writing into streamDir alongside the HLS output.
+func generateDASH(path string, ladder []rendition, audio StreamInfo, hasAudio bool, enc encoderChoice, streamDir string) error {
+	cmdArgs := append([]string{}, enc.hwaccelArgs()...)
+	cmdArgs = append(cmdArgs, "-i", path)
+	for i, r := range ladder {
+		args, err := renditionArgs(enc, audio, hasAudio, i, r)
+		if err != nil {
+			return err
+		}
+		cmdArgs = append(cmdArgs, args...)
+	}
+	cmdArgs = append(cmdArgs, "-f", "dash", filepath.Join(streamDir, "manifest.mpd"))
+
+	cmd := exec.Command("ffmpeg", cmdArgs...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg dash encode failed: %s: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var hwaccelFlag = flag.String("hwaccel", "auto", "video encoder: auto|none|nvenc|qsv|vaapi|videotoolbox")
+
+// encoderChoice describes the ffmpeg video encoder to use and how to
+// translate the tool's shared -c/-p flags into that encoder's own
+// rate-control options.
+type encoderChoice struct {
+	name string // ffmpeg -c:v value, e.g. "h264_nvenc"
+}
+
+// hwaccelArgs returns the global, before -i device/frames setup an encoder
+// needs. nvenc and videotoolbox work with no extra setup; vaapi and qsv
+// need an explicit device or ffmpeg fails at encode time even though
+// -encoders reports the codec as compiled in.
+func (e encoderChoice) hwaccelArgs() []string {
+	switch e.name {
+	case "h264_vaapi":
+		return []string{"-vaapi_device", "/dev/dri/renderD128"}
+	case "h264_qsv":
+		return []string{"-init_hw_device", "qsv=hw", "-filter_hw_device", "hw"}
+	default:
+		return nil
+	}
+}
+
+// rateControlArgs returns the -c:v value plus the encoder-specific flags
+// that correspond to this tool's shared crf/preset flags.
+func (e encoderChoice) rateControlArgs(crf, preset string) []string {
+	switch e.name {
+	case "h264_nvenc":
+		return []string{"-c:v", e.name, "-cq", crf, "-preset", nvencPreset(preset)}
+	case "h264_qsv":
+		return []string{"-c:v", e.name, "-global_quality", crf, "-preset", preset}
+	case "h264_vaapi":
+		return []string{"-vf", "format=nv12,hwupload", "-c:v", e.name, "-qp", crf}
+	case "h264_videotoolbox":
+		return []string{"-c:v", e.name, "-q:v", crf}
+	default:
+		return []string{"-c:v", "libx264", "-crf", crf, "-preset", preset}
+	}
+}
+
+// scaleFilter returns the -vf/-vf:N filter chain that scales to height,
+// adding the format=nv12,hwupload steps h264_vaapi needs to get the scaled
+// frame onto its hardware surface before encoding — the same requirement
+// rateControlArgs accounts for on the single-rendition path, but renditionArgs
+// already owns the -vf:%d scale filter and must fold it in rather than
+// emitting a second, conflicting -vf:%d for the same stream.
+func (e encoderChoice) scaleFilter(height int) string {
+	scale := fmt.Sprintf("scale=-2:%d", height)
+	if e.name == "h264_vaapi" {
+		return scale + ",format=nv12,hwupload"
+	}
+	return scale
+}
+
+// indexedPresetArgs returns the per-output-stream preset flag for encoders
+// that have one, using ffmpeg's "-preset:v:N" stream-specifier form. Used by
+// convertHLS, which drives several renditions of the same encoder from a
+// single ffmpeg invocation.
+func (e encoderChoice) indexedPresetArgs(i int, preset string) []string {
+	switch e.name {
+	case "h264_nvenc":
+		return []string{fmt.Sprintf("-preset:v:%d", i), nvencPreset(preset)}
+	case "h264_qsv":
+		return []string{fmt.Sprintf("-preset:v:%d", i), preset}
+	case "libx264":
+		return []string{fmt.Sprintf("-preset:v:%d", i), preset}
+	default:
+		return nil
+	}
+}
+
+// nvencPreset maps libx264-style preset names to nvenc's p1..p7 scale, since
+// nvenc doesn't understand "medium"/"slow"/etc.
+func nvencPreset(preset string) string {
+	switch preset {
+	case "ultrafast", "superfast", "veryfast":
+		return "p1"
+	case "faster", "fast":
+		return "p3"
+	case "medium":
+		return "p4"
+	case "slow":
+		return "p5"
+	case "slower":
+		return "p6"
+	case "veryslow":
+		return "p7"
+	default:
+		return "p4"
+	}
+}
+
+var (
+	hwaccelOnce   sync.Once
+	hwaccelResult encoderChoice
+)
+
+// knownEncoders lists the hardware encoders we probe for, in priority order.
+var knownEncoders = []string{"h264_nvenc", "h264_qsv", "h264_vaapi", "h264_videotoolbox"}
+
+// encoderHwaccelMethod maps each hardware encoder to the -hwaccels name
+// ffmpeg must also report as available, since an encoder can be compiled in
+// without the hwaccel backend it needs actually working on this machine.
+var encoderHwaccelMethod = map[string]string{
+	"h264_nvenc":        "cuda",
+	"h264_qsv":          "qsv",
+	"h264_vaapi":        "vaapi",
+	"h264_videotoolbox": "videotoolbox",
+}
+
+// selectEncoder resolves the -hwaccel flag to a concrete encoder, probing
+// ffmpeg's available encoders once per process and caching the result.
+func selectEncoder() encoderChoice {
+	hwaccelOnce.Do(func() {
+		hwaccelResult = probeEncoder(*hwaccelFlag)
+	})
+	return hwaccelResult
+}
+
+func probeEncoder(want string) encoderChoice {
+	if want == "none" {
+		return encoderChoice{name: "libx264"}
+	}
+	if want != "auto" {
+		return encoderChoice{name: "h264_" + want}
+	}
+
+	encoders, err := ffmpegEncoders()
+	if err != nil {
+		log.Printf("unable to probe ffmpeg encoders, falling back to libx264: %s", err)
+		return encoderChoice{name: "libx264"}
+	}
+	hwaccels, err := ffmpegHwaccels()
+	if err != nil {
+		log.Printf("unable to probe ffmpeg hwaccels, falling back to libx264: %s", err)
+		return encoderChoice{name: "libx264"}
+	}
+
+	for _, candidate := range knownEncoders {
+		if encoders[candidate] && hwaccels[encoderHwaccelMethod[candidate]] {
+			log.Printf("using hardware encoder %s", candidate)
+			return encoderChoice{name: candidate}
+		}
+	}
+	return encoderChoice{name: "libx264"}
+}
+
+// ffmpegEncoders runs `ffmpeg -hide_banner -encoders` and returns the set of
+// encoder names ffmpeg reports as available.
+func ffmpegEncoders() (map[string]bool, error) {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	encoders := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		encoders[fields[1]] = true
+	}
+	return encoders, nil
+}
+
+// ffmpegHwaccels runs `ffmpeg -hide_banner -hwaccels` and returns the set of
+// hardware acceleration methods ffmpeg reports as available on this machine.
+func ffmpegHwaccels() (map[string]bool, error) {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-hwaccels").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	hwaccels := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		hwaccels[line] = true
+	}
+	return hwaccels, nil
+}
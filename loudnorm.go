@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+var (
+	loudnormFlag = flag.String("loudnorm", "", "loudness-normalize audio with two-pass EBU R128 (pass 'single' for one-pass mode when the audio is already AAC)")
+	loudnormI    = flag.Float64("I", -16, "loudnorm integrated loudness target, in LUFS")
+	loudnormTP   = flag.Float64("TP", -1.5, "loudnorm true peak target, in dBTP")
+	loudnormLRA  = flag.Float64("LRA", 11, "loudnorm loudness range target, in LU")
+)
+
+// loudnormMeasurement is the final JSON block ffmpeg's loudnorm filter
+// writes to stderr during the analysis pass.
+type loudnormMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// measureLoudness runs ffmpeg's loudnorm filter in analysis mode (pass 1 of
+// the two-pass EBU R128 workflow) and parses the measurement it prints as a
+// trailing JSON block on stderr.
+func measureLoudness(path string) (*loudnormMeasurement, error) {
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:print_format=json", *loudnormI, *loudnormTP, *loudnormLRA)
+	cmd := exec.Command("ffmpeg", "-i", path, "-af", filter, "-f", "null", "-")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("loudnorm analysis pass failed: %s: %s", err, out)
+	}
+
+	start := strings.LastIndex(string(out), "{")
+	end := strings.LastIndex(string(out), "}")
+	if start == -1 || end == -1 || end < start {
+		return nil, fmt.Errorf("unable to find loudnorm measurement in ffmpeg output")
+	}
+
+	var m loudnormMeasurement
+	if err := json.Unmarshal(out[start:end+1], &m); err != nil {
+		return nil, fmt.Errorf("unable to parse loudnorm measurement: %s", err)
+	}
+	return &m, nil
+}
+
+// twoPassFilter builds the second-pass loudnorm filter string, feeding the
+// first pass's measurement in via measured_I/measured_TP/etc so ffmpeg
+// applies a linear correction instead of re-measuring.
+func twoPassFilter(m *loudnormMeasurement) string {
+	return fmt.Sprintf(
+		"loudnorm=I=%g:TP=%g:LRA=%g:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		*loudnormI, *loudnormTP, *loudnormLRA,
+		m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset,
+	)
+}
+
+// singlePassFilter builds a one-pass loudnorm filter with no prior
+// measurement, for when -loudnorm=single was requested.
+func singlePassFilter() string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", *loudnormI, *loudnormTP, *loudnormLRA)
+}
+
+// loudnormFilterFor resolves the -loudnorm flag into the -af filter string
+// convert() should pass to ffmpeg's encode pass. Pass 1 (the analysis run)
+// is skipped when the audio is already AAC and the user passed
+// -loudnorm=single, falling back to one-pass mode.
+func loudnormFilterFor(path string, audioCodec string) (string, error) {
+	if *loudnormFlag == "" {
+		return "", nil
+	}
+	if *loudnormFlag == "single" && audioCodec == "aac" {
+		return singlePassFilter(), nil
+	}
+
+	log.Printf("Measuring loudness of %s...", path)
+	m, err := measureLoudness(path)
+	if err != nil {
+		return "", err
+	}
+	return twoPassFilter(m), nil
+}
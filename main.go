@@ -33,16 +33,19 @@ type FfprobeOutput struct {
 }
 
 type StreamInfo struct {
-	Index         int    `json:"index"`
-	CodecName     string `json:"codec_name"`
-	CodecLongName string `json:"codec_long_name"`
-	CodecType     string `json:"codec_type"`
+	Index         int               `json:"index"`
+	CodecName     string            `json:"codec_name"`
+	CodecLongName string            `json:"codec_long_name"`
+	CodecType     string            `json:"codec_type"`
+	Tags          map[string]string `json:"tags"`
+	Disposition   map[string]int    `json:"disposition"`
 }
 
 type FormatInfo struct {
 	Filename       string `json:"filename"`
 	FormatName     string `json:"format_name"`
 	FormatLongName string `json:"format_long_name"`
+	Duration       string `json:"duration"`
 }
 
 func main() {
@@ -57,6 +60,13 @@ func main() {
 	if flag.NArg() == 0 {
 		flag.Usage()
 	}
+
+	store, err := newJobStore(*stateDirFlag)
+	if err != nil {
+		log.Fatalf("unable to open job store: %s", err)
+	}
+	jobs = store
+
 	if *watchFlag {
 		if *outputDir == "" {
 			log.Fatal("Must specify output directory with watch")
@@ -66,15 +76,33 @@ func main() {
 	}
 
 	inputs := flag.Args()
+	seen := make(map[string]bool)
 	filesToConvert := make([]string, 0)
 
 	for _, input := range inputs {
 		subFiles := gatherFiles(input, *recursive)
 		for _, entry := range subFiles {
+			if jobs.shouldSkip(entry) {
+				log.Printf("Skipping %s, already converted", entry)
+				continue
+			}
+			if !seen[entry] {
+				seen[entry] = true
+				jobs.markPending(entry)
+				filesToConvert = append(filesToConvert, entry)
+			}
+		}
+	}
+
+	for _, entry := range jobs.unfinished() {
+		if !seen[entry] {
+			seen[entry] = true
 			filesToConvert = append(filesToConvert, entry)
 		}
 	}
 
+	setJobTotal(len(filesToConvert))
+
 	var wg sync.WaitGroup
 	fileChan := startWorkers(&wg)
 
@@ -127,10 +155,18 @@ func gatherFiles(root string, recursive bool) []string {
 func convertWorker(fileChan <-chan string, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for filepath := range fileChan {
+		if jobs.shouldSkip(filepath) {
+			log.Printf("Skipping %s, already converted", filepath)
+			continue
+		}
+		jobs.markRunning(filepath)
 		_, err := convert(filepath)
 		if err != nil {
 			log.Printf("error converting: %s", err)
+			jobs.markFailed(filepath, err)
+			continue
 		}
+		jobs.markDone(filepath)
 	}
 }
 
@@ -158,6 +194,14 @@ func getInfo(path string) FfprobeOutput {
 }
 
 func convert(path string) (outpath string, err error) {
+	if *hlsFlag {
+		dir := *outputDir
+		if dir == "" {
+			dir = filepath.Dir(path)
+		}
+		return convertHLS(path, dir)
+	}
+
 	info := getInfo(path)
 	var audioCodec string
 	var videoCodec string
@@ -178,7 +222,7 @@ func convert(path string) (outpath string, err error) {
 		outpath = filepath.Join(*outputDir, outputFilename)
 	}
 
-	if audioCodec == "aac" && videoCodec == "h264" && filepath.Ext(path) == ".mp4" {
+	if audioCodec == "aac" && videoCodec == "h264" && filepath.Ext(path) == ".mp4" && canCopyThrough(info) {
 		log.Printf("Conversion unneccessary for %s", path)
 		if *deleteOriginal {
 			err = os.Rename(path, outpath)
@@ -188,28 +232,63 @@ func convert(path string) (outpath string, err error) {
 		if err != nil {
 			return path, err
 		}
+		if *thumbsFlag {
+			if err := generateThumbnails(outpath, filepath.Dir(outpath), parseDuration(info.Format.Duration)); err != nil {
+				log.Printf("unable to generate thumbnails for %s: %s", outpath, err)
+			}
+		}
 		return outpath, nil
 	}
 
+	loudnormFilter, err := loudnormFilterFor(path, audioCodec)
+	if err != nil {
+		return path, err
+	}
+
 	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s", outputFilename))
-	cmdArgs := []string{"-i", path}
-	cmdArgs = append(cmdArgs, "-c:v", "libx264", "-crf", *crf, "-preset", *preset)
-	cmdArgs = append(cmdArgs, "-c:a", "aac", "-strict", "experimental")
-	cmdArgs = append(cmdArgs, "-b:a", "192k", "-ac", "2")
+	enc := selectEncoder()
+	cmdArgs := append([]string{}, enc.hwaccelArgs()...)
+	cmdArgs = append(cmdArgs, "-i", path)
+	cmdArgs = append(cmdArgs, streamMapArgs(info)...)
+	cmdArgs = append(cmdArgs, enc.rateControlArgs(*crf, *preset)...)
+	cmdArgs = append(cmdArgs, "-strict", "experimental")
+	if loudnormFilter != "" {
+		cmdArgs = append(cmdArgs, "-af", loudnormFilter)
+	}
+	cmdArgs = append(cmdArgs, "-progress", "pipe:1", "-nostats")
 	cmdArgs = append(cmdArgs, tmp)
 
 	log.Printf("Converting %s to %s...", path, outpath)
 	cmd := exec.Command("ffmpeg", cmdArgs...)
-	err = cmd.Run()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return path, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err = cmd.Start(); err != nil {
+		return path, err
+	}
+
+	t := newTracker(filepath.Base(path), parseDuration(info.Format.Duration))
+	t.run(stdout)
+
+	err = cmd.Wait()
 	if err == nil {
 		os.Rename(tmp, outpath)
 		log.Printf("Finished converting %s to %s.", path, outpath)
+		if *thumbsFlag {
+			if thumbErr := generateThumbnails(outpath, filepath.Dir(outpath), parseDuration(info.Format.Duration)); thumbErr != nil {
+				log.Printf("unable to generate thumbnails for %s: %s", outpath, thumbErr)
+			}
+		}
 		if *deleteOriginal {
 			log.Printf("Removing original")
 			os.Remove(path)
 		}
 	} else {
-		log.Printf("Unable to convert file %s: %s", path, err)
+		log.Printf("Unable to convert file %s: %s\nffmpeg stderr:\n%s", path, err, stderr.String())
 	}
 	return
 }
@@ -255,6 +334,12 @@ func watch(path string) {
 	var wg sync.WaitGroup
 	fileChan := startWorkers(&wg)
 
+	go func() {
+		for _, file := range jobs.unfinished() {
+			fileChan <- file
+		}
+	}()
+
 	go func() {
 		for {
 			select {
@@ -265,12 +350,14 @@ func watch(path string) {
 						files := gatherFiles(event.Name, true)
 						for _, file := range files {
 							if isVid(file) {
+								jobs.markPending(file)
 								fileChan <- file
 							}
 						}
 						continue
 					}
 					if isVid(event.Name) {
+						jobs.markPending(event.Name)
 						fileChan <- event.Name
 					}
 				}
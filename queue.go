@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var stateDirFlag = flag.String("state-dir", "", "directory to persist job queue state in, for crash-safe resumption")
+
+// jobs is the process-wide job store used by convertWorker and watch() to
+// skip already-converted files and resume interrupted runs. It is nil (and
+// every method a no-op) unless -state-dir is set.
+var jobs *jobStore
+
+type jobState string
+
+const (
+	jobPending jobState = "pending"
+	jobRunning jobState = "running"
+	jobDone    jobState = "done"
+	jobFailed  jobState = "failed"
+)
+
+// jobRecord is the persisted state for a single input file.
+type jobRecord struct {
+	Path      string   `json:"path"`
+	Hash      string   `json:"hash"`
+	State     jobState `json:"state"`
+	Attempts  int      `json:"attempts"`
+	LastError string   `json:"last_error,omitempty"`
+}
+
+// jobStore is a small on-disk JSON store tracking conversion progress per
+// input file, so a crash or restart can resume instead of starting over or
+// re-converting files that already finished.
+type jobStore struct {
+	mu   sync.Mutex
+	file string
+	jobs map[string]jobRecord // keyed by path
+}
+
+// newJobStore loads (or creates) the job store under dir. A nil store is
+// returned when dir is empty, and all of its methods become no-ops, so
+// callers don't need to branch on whether -state-dir was passed.
+func newJobStore(dir string) (*jobStore, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &jobStore{file: filepath.Join(dir, "jobs.json"), jobs: make(map[string]jobRecord)}
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return s, nil
+	}
+	var jobs []jobRecord
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		s.jobs[j.Path] = j
+	}
+	return s, nil
+}
+
+func (s *jobStore) save() error {
+	jobs := make([]jobRecord, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// hashFile fingerprints a file by size, mtime, and the sha256 of its first
+// megabyte, which is cheap enough to run on every file while still catching
+// content changes that a size+mtime check alone would miss.
+func hashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, 1<<20); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%d-%s", info.Size(), info.ModTime().UnixNano(), hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// shouldSkip reports whether path has already been successfully converted
+// and hasn't changed since, so callers can skip re-converting it.
+func (s *jobStore) shouldSkip(path string) bool {
+	if s == nil {
+		return false
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[path]
+	return ok && job.State == jobDone && job.Hash == hash
+}
+
+// markPending records that path has been discovered and is queued for
+// conversion but not yet picked up by a worker. Called at discovery time
+// (gatherFiles results, the watcher's Create handler) before the path is
+// ever handed to the (unbuffered) work channel, so a crash while files are
+// queued up still leaves a record for unfinished() to resume from.
+func (s *jobStore) markPending(path string) {
+	if s == nil {
+		return
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		log.Printf("unable to hash %s for job store: %s", path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[path]
+	if ok && job.State == jobDone && job.Hash == hash {
+		return
+	}
+	job.Path = path
+	job.Hash = hash
+	job.State = jobPending
+	s.jobs[path] = job
+	if err := s.save(); err != nil {
+		log.Printf("unable to save job store: %s", err)
+	}
+}
+
+// markRunning records that path is about to be converted, storing its
+// current content hash and counting one more attempt.
+func (s *jobStore) markRunning(path string) {
+	if s == nil {
+		return
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		log.Printf("unable to hash %s for job store: %s", path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[path]
+	job.Path = path
+	job.Hash = hash
+	job.State = jobRunning
+	job.Attempts++
+	s.jobs[path] = job
+	if err := s.save(); err != nil {
+		log.Printf("unable to save job store: %s", err)
+	}
+}
+
+func (s *jobStore) markDone(path string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[path]
+	job.State = jobDone
+	job.LastError = ""
+	s.jobs[path] = job
+	if err := s.save(); err != nil {
+		log.Printf("unable to save job store: %s", err)
+	}
+}
+
+func (s *jobStore) markFailed(path string, convertErr error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[path]
+	job.State = jobFailed
+	job.LastError = convertErr.Error()
+	s.jobs[path] = job
+	if err := s.save(); err != nil {
+		log.Printf("unable to save job store: %s", err)
+	}
+}
+
+// unfinished returns the paths of jobs left pending or running by a prior,
+// presumably crashed or interrupted, run.
+func (s *jobStore) unfinished() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, j := range s.jobs {
+		if j.State == jobPending || j.State == jobRunning {
+			if _, err := os.Stat(j.Path); err == nil {
+				paths = append(paths, j.Path)
+			}
+		}
+	}
+	return paths
+}
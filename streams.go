@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	audioLangsFlag = flag.String("audio-langs", "", "comma separated list of audio languages to keep, e.g. en,ja (default: keep all)")
+	subLangsFlag   = flag.String("sub-langs", "", "comma separated list of subtitle languages to keep, e.g. en (default: keep all)")
+)
+
+// passthroughAudioCodecs are already acceptable inside an mp4 container, so
+// they're copied through rather than re-encoded.
+var passthroughAudioCodecs = map[string]bool{"aac": true, "ac3": true}
+
+// bitmapSubtitleCodecs are image-based subtitle formats that mov_text (the
+// only subtitle codec mp4 supports) can't hold, so they need transcoding.
+var bitmapSubtitleCodecs = map[string]bool{"hdmv_pgs_subtitle": true, "dvb_subtitle": true, "dvb_teletext": true}
+
+// streamLanguage returns a stream's language tag, or "" if it has none.
+func streamLanguage(s StreamInfo) string {
+	return s.Tags["language"]
+}
+
+// dispositionValue returns the -disposition:a:N/-disposition:s:N value that
+// preserves which of the source's kept audio/subtitle tracks was marked
+// default, since ffmpeg otherwise defaults output stream 0 of each type
+// regardless of what the source actually flagged.
+func dispositionValue(s StreamInfo) string {
+	if s.Disposition["default"] != 0 {
+		return "default"
+	}
+	return "0"
+}
+
+// langKept reports whether a stream should be kept given a -audio-langs/
+// -sub-langs filter list. An empty filter keeps everything; a stream with
+// no language tag is always kept, since there's nothing to filter it by.
+func langKept(lang string, allowed []string) bool {
+	if len(allowed) == 0 || lang == "" {
+		return true
+	}
+	for _, a := range allowed {
+		if a == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// canCopyThrough reports whether path's streams are simple enough for
+// convert() to take its raw-copy/rename shortcut instead of running
+// streamMapArgs() through ffmpeg: no -audio-langs/-sub-langs filtering
+// requested, no -loudnorm pass to apply (loudnorm needs an actual encode,
+// not a copy), at most one audio track, that track already a passthrough
+// codec (AAC or AC3), and no subtitle tracks to transcode or drop.
+func canCopyThrough(info FfprobeOutput) bool {
+	if *audioLangsFlag != "" || *subLangsFlag != "" || *loudnormFlag != "" {
+		return false
+	}
+	audioStreams := 0
+	for _, s := range info.Streams {
+		switch s.CodecType {
+		case "audio":
+			audioStreams++
+			if !passthroughAudioCodecs[s.CodecName] {
+				return false
+			}
+		case "subtitle":
+			return false
+		}
+	}
+	return audioStreams <= 1
+}
+
+// firstKeptAudioStream returns the first audio stream that passes the
+// -audio-langs filter (or simply the first audio stream, if no filter is
+// set), for callers like convertHLS that only support a single shared
+// audio track per rendition rather than streamMapArgs's full multi-track
+// mapping.
+func firstKeptAudioStream(info FfprobeOutput) (StreamInfo, bool) {
+	langs := splitNonEmpty(*audioLangsFlag)
+	for _, s := range info.Streams {
+		if s.CodecType == "audio" && langKept(streamLanguage(s), langs) {
+			return s, true
+		}
+	}
+	return StreamInfo{}, false
+}
+
+// streamMapArgs builds the -map/-c:a/-c:s arguments that preserve every
+// video, audio, and subtitle stream ffmpeg would otherwise drop or
+// mis-select: one video stream, every audio track (copying AAC/AC3 through
+// and transcoding anything else to AAC), and every subtitle track (copying
+// already-text subtitles, transcoding bitmap ones to mov_text), each
+// filtered by -audio-langs/-sub-langs if set and each carrying its source
+// disposition forward via dispositionValue. Audio is never stream-copied
+// when -loudnorm is set, since ffmpeg rejects filtering a copied output and
+// convert() always appends a global -af loudnorm filter in that case.
+func streamMapArgs(info FfprobeOutput) []string {
+	audioLangs := splitNonEmpty(*audioLangsFlag)
+	subLangs := splitNonEmpty(*subLangsFlag)
+	normalizing := *loudnormFlag != ""
+
+	args := []string{"-map", "0:v:0"}
+
+	audioOut := 0
+	for _, s := range info.Streams {
+		if s.CodecType != "audio" {
+			continue
+		}
+		if !langKept(streamLanguage(s), audioLangs) {
+			continue
+		}
+		args = append(args, "-map", fmt.Sprintf("0:%d", s.Index))
+		if passthroughAudioCodecs[s.CodecName] && !normalizing {
+			args = append(args, fmt.Sprintf("-c:a:%d", audioOut), "copy")
+		} else {
+			args = append(args, fmt.Sprintf("-c:a:%d", audioOut), "aac", fmt.Sprintf("-b:a:%d", audioOut), "192k")
+		}
+		args = append(args, fmt.Sprintf("-disposition:a:%d", audioOut), dispositionValue(s))
+		audioOut++
+	}
+
+	subOut := 0
+	for _, s := range info.Streams {
+		if s.CodecType != "subtitle" {
+			continue
+		}
+		if !langKept(streamLanguage(s), subLangs) {
+			continue
+		}
+		args = append(args, "-map", fmt.Sprintf("0:%d?", s.Index))
+		if bitmapSubtitleCodecs[s.CodecName] {
+			args = append(args, fmt.Sprintf("-c:s:%d", subOut), "mov_text")
+		} else {
+			args = append(args, fmt.Sprintf("-c:s:%d", subOut), "copy")
+		}
+		args = append(args, fmt.Sprintf("-disposition:s:%d", subOut), dispositionValue(s))
+		subOut++
+	}
+
+	return args
+}
+
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
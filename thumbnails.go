@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	thumbsFlag         = flag.Bool("thumbs", false, "generate a thumbnail sprite sheet and WebVTT sidecar alongside each conversion")
+	thumbsIntervalFlag = flag.Int("thumbs-interval", 10, "seconds between sprite-sheet thumbnails")
+	thumbsColumnsFlag  = flag.Int("thumbs-columns", 10, "columns in the sprite sheet grid")
+	thumbsWidthFlag    = flag.Int("thumbs-width", 160, "width in pixels of each sprite-sheet thumbnail")
+)
+
+// generateThumbnails extracts evenly spaced frames from path, composites
+// them into a single JPEG sprite sheet, and writes a sidecar WebVTT file
+// mapping timecode ranges to sprite-sheet coordinates, for use by a video
+// player's scrubber preview.
+func generateThumbnails(path string, outDir string, duration float64) error {
+	if duration <= 0 {
+		log.Printf("Skipping thumbnails for %s: unknown duration", path)
+		return nil
+	}
+
+	interval := float64(*thumbsIntervalFlag)
+	var timestamps []float64
+	for t := 0.0; t < duration; t += interval {
+		timestamps = append(timestamps, t)
+	}
+	if len(timestamps) == 0 {
+		return nil
+	}
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	spriteName := base + "-sprite.jpg"
+	vttName := base + "-thumbs.vtt"
+
+	frames := make([]image.Image, 0, len(timestamps))
+	for _, ts := range timestamps {
+		frame, err := extractFrame(path, ts, *thumbsWidthFlag)
+		if err != nil {
+			return fmt.Errorf("extracting thumbnail at %.2fs: %s", ts, err)
+		}
+		frames = append(frames, frame)
+	}
+
+	columns := *thumbsColumnsFlag
+	if columns <= 0 {
+		columns = 1
+	}
+	sheet, cellW, cellH := compositeSprite(frames, columns)
+
+	spritePath := filepath.Join(outDir, spriteName)
+	if err := writeJPEG(spritePath, sheet); err != nil {
+		return err
+	}
+
+	vttPath := filepath.Join(outDir, vttName)
+	return writeThumbsVTT(vttPath, spriteName, timestamps, interval, columns, cellW, cellH)
+}
+
+// extractFrame grabs a single frame from path at ts seconds and decodes it.
+func extractFrame(path string, ts float64, width int) (image.Image, error) {
+	tmp, err := ioutil.TempFile("", "thumb-*.jpg")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	cmdArgs := []string{
+		"-ss", fmt.Sprintf("%.3f", ts),
+		"-i", path,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-1", width),
+		"-y", tmpPath,
+	}
+	cmd := exec.Command("ffmpeg", cmdArgs...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %s", err, out)
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return jpeg.Decode(f)
+}
+
+// compositeSprite lays frames out left to right, top to bottom, into a grid
+// with the given number of columns, padding every cell to the size of the
+// largest frame. It returns the composited sheet and the cell dimensions
+// used, which the caller needs to compute WebVTT xywh coordinates.
+func compositeSprite(frames []image.Image, columns int) (image.Image, int, int) {
+	cellW, cellH := 0, 0
+	for _, f := range frames {
+		b := f.Bounds()
+		if b.Dx() > cellW {
+			cellW = b.Dx()
+		}
+		if b.Dy() > cellH {
+			cellH = b.Dy()
+		}
+	}
+
+	rows := (len(frames) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0, cellW*columns, cellH*rows))
+
+	for i, f := range frames {
+		col := i % columns
+		row := i / columns
+		dst := image.Rect(col*cellW, row*cellH, (col+1)*cellW, (row+1)*cellH)
+		draw.Draw(sheet, dst, f, f.Bounds().Min, draw.Src)
+	}
+
+	return sheet, cellW, cellH
+}
+
+func writeJPEG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return jpeg.Encode(f, img, &jpeg.Options{Quality: 85})
+}
+
+// writeThumbsVTT writes a WebVTT file with one cue per sprite-sheet cell,
+// each pointing at that frame's region of spriteName via a #xywh= fragment.
+func writeThumbsVTT(path string, spriteName string, timestamps []float64, interval float64, columns, cellW, cellH int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "WEBVTT")
+	fmt.Fprintln(f)
+	for i, ts := range timestamps {
+		col := i % columns
+		row := i / columns
+		x, y := col*cellW, row*cellH
+
+		end := ts + interval
+		fmt.Fprintf(f, "%s --> %s\n", vttTimestamp(ts), vttTimestamp(end))
+		fmt.Fprintf(f, "%s#xywh=%d,%d,%d,%d\n\n", spriteName, x, y, cellW, cellH)
+	}
+	return nil
+}
+
+func vttTimestamp(seconds float64) string {
+	total := int(seconds)
+	hours := total / 3600
+	minutes := (total % 3600) / 60
+	secs := total % 60
+	millis := int((seconds - float64(total)) * 1000)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, secs, millis)
+}
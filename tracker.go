@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var progressJSONFlag = flag.String("progress-json", "", "write NDJSON progress events to this file, or '-' for stdout")
+
+var (
+	jobCounter int64
+	jobTotal   int64
+)
+
+// setJobTotal records how many files this run will convert, so progress
+// lines can show "[n/total]". watch() never calls it, since the total
+// isn't known up front, so its progress lines show only "[n]".
+func setJobTotal(n int) {
+	atomic.StoreInt64(&jobTotal, int64(n))
+}
+
+// nextJobIndex returns this job's 1-based position and the run's total,
+// incrementing the shared counter. Total is 0 if it was never set.
+func nextJobIndex() (index, total int64) {
+	return atomic.AddInt64(&jobCounter, 1), atomic.LoadInt64(&jobTotal)
+}
+
+// progressEvent is the shape written to -progress-json, one object per line.
+type progressEvent struct {
+	File       string  `json:"file"`
+	JobIndex   int64   `json:"job_index"`
+	JobTotal   int64   `json:"job_total,omitempty"`
+	Frame      int64   `json:"frame"`
+	FPS        float64 `json:"fps"`
+	Speed      float64 `json:"speed"`
+	Percent    float64 `json:"percent"`
+	ETASeconds float64 `json:"eta_seconds"`
+	Done       bool    `json:"done"`
+}
+
+var (
+	jsonOutMu   sync.Mutex
+	jsonOutOnce sync.Once
+	jsonOutW    io.Writer
+)
+
+// jsonWriter returns the shared writer for -progress-json, opening the file
+// once per process.
+func jsonWriter() io.Writer {
+	jsonOutOnce.Do(func() {
+		switch *progressJSONFlag {
+		case "":
+			jsonOutW = nil
+		case "-":
+			jsonOutW = os.Stdout
+		default:
+			f, err := os.OpenFile(*progressJSONFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				log.Printf("unable to open -progress-json file %s: %s", *progressJSONFlag, err)
+				return
+			}
+			jsonOutW = f
+		}
+	})
+	return jsonOutW
+}
+
+// tracker parses an ffmpeg `-progress pipe:1 -nostats` stream for a single
+// file and emits periodic log lines plus, optionally, NDJSON events.
+type tracker struct {
+	file     string
+	index    int64
+	total    int64
+	duration float64 // seconds, 0 if unknown
+}
+
+func newTracker(file string, duration float64) *tracker {
+	index, total := nextJobIndex()
+	return &tracker{file: file, index: index, total: total, duration: duration}
+}
+
+// run reads progress from r until EOF, logging one line per reporting cycle
+// (ffmpeg emits a "progress=continue" or "progress=end" line to mark the end
+// of each cycle's key=value block).
+func (t *tracker) run(r io.Reader) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		fields[key] = value
+		if key == "progress" {
+			t.report(fields, value == "end")
+			fields = make(map[string]string)
+		}
+	}
+}
+
+func (t *tracker) report(fields map[string]string, done bool) {
+	outTimeMS, _ := strconv.ParseInt(fields["out_time_ms"], 10, 64)
+	frame, _ := strconv.ParseInt(fields["frame"], 10, 64)
+	fps, _ := strconv.ParseFloat(fields["fps"], 64)
+	speed, _ := strconv.ParseFloat(strings.TrimSuffix(fields["speed"], "x"), 64)
+
+	outTimeSeconds := float64(outTimeMS) / 1000000.0
+	var percent, etaSeconds float64
+	if t.duration > 0 {
+		percent = outTimeSeconds / t.duration * 100
+		if percent > 100 {
+			percent = 100
+		}
+		if speed > 0 {
+			etaSeconds = (t.duration - outTimeSeconds) / speed
+			if etaSeconds < 0 {
+				etaSeconds = 0
+			}
+		}
+	}
+
+	prefix := fmt.Sprintf("[%d]", t.index)
+	if t.total > 0 {
+		prefix = fmt.Sprintf("[%d/%d]", t.index, t.total)
+	}
+	if t.duration > 0 {
+		log.Printf("%s %s %.0f%% @ %.1fx ETA %s", prefix, t.file, percent, speed, formatETA(etaSeconds))
+	} else {
+		log.Printf("%s %s frame=%d fps=%.1f speed=%.1fx", prefix, t.file, frame, fps, speed)
+	}
+
+	if w := jsonWriter(); w != nil {
+		event := progressEvent{
+			File: t.file, JobIndex: t.index, JobTotal: t.total,
+			Frame: frame, FPS: fps, Speed: speed,
+			Percent: percent, ETASeconds: etaSeconds, Done: done,
+		}
+		jsonOutMu.Lock()
+		enc := json.NewEncoder(w)
+		enc.Encode(event)
+		jsonOutMu.Unlock()
+	}
+}
+
+func formatETA(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	d = d.Round(time.Second)
+	minutes := int(d.Minutes())
+	secs := int(d.Seconds()) % 60
+	return fmt.Sprintf("%02d:%02d", minutes, secs)
+}
+
+// parseDuration parses ffprobe's format.duration string ("123.456") into
+// seconds, returning 0 if it's missing or unparseable.
+func parseDuration(s string) float64 {
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return d
+}